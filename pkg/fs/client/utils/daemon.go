@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
@@ -9,7 +10,6 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
@@ -25,129 +25,64 @@ type DaemonAttr struct {
 	Files         []**os.File // files to keep open in the daemon
 	Stdout        *os.File    // redirect stdout/stderr to it
 	OnExit        func(stage int) error
-}
-
-func MakeDaemon(attrs *DaemonAttr) (io.Reader, io.Reader, error) {
-	stage, advanceStage, resetEnv := getStage()
-
-	fatal := func(err error) (io.Reader, io.Reader, error) {
-		if stage > 0 {
-			os.Exit(1)
-		}
-		resetErr := resetEnv()
-		if err != nil {
-			return nil, nil, resetErr
-		}
-		return nil, nil, err
-	}
+	PidFile       string // if set, the final daemon writes its pid here and holds an exclusive lock on it
 
-	fileCount := 3 + len(attrs.Files)
-	files := make([]*os.File, fileCount, fileCount+2)
+	OnReload        func() error                    // invoked on SIGHUP
+	OnShutdown      func(ctx context.Context) error // invoked on SIGTERM/SIGINT
+	OnUsr1          func()                          // invoked on SIGUSR1, e.g. to dump stats
+	OnUsr2          func()                          // invoked on SIGUSR2, e.g. to rotate logs
+	ShutdownTimeout time.Duration                   // bound on OnShutdown; defaults to 30s
+}
 
-	if stage == 0 {
-		nullDev, err := os.OpenFile("/dev/null", 0, 0)
-		if err != nil {
-			return fatal(err)
-		}
-		files[0] = nullDev
-		if attrs.Stdout != nil {
-			files[1], files[2] = attrs.Stdout, attrs.Stdout
-		} else {
-			files[1], files[2] = nullDev, nullDev
-		}
+// Daemonizer backgrounds the current process per attrs. The stage-tracking
+// scheme used to get there is platform-specific (see daemon_unix.go and
+// daemon_windows.go); Daemonizer and DaemonAttr are the stable surface the
+// rest of pfs-fuse codes against.
+type Daemonizer interface {
+	Daemonize(attrs *DaemonAttr) (stdout, stderr io.Reader, err error)
+}
 
-		fd := 3
-		for _, fPtr := range attrs.Files {
-			files[fd] = *fPtr
-			saveFileName(fd, (*fPtr).Name())
-			fd++
-		}
-	} else {
-		files[0], files[1], files[2] = os.Stdin, os.Stdout, os.Stderr
+// defaultDaemonizer is set by daemon_unix.go or daemon_windows.go's init.
+var defaultDaemonizer Daemonizer
 
-		fd := 3
-		for _, fPtr := range attrs.Files {
-			*fPtr = os.NewFile(uintptr(fd), getFileName(fd))
-			syscall.CloseOnExec(fd)
-			files[fd] = *fPtr
-			fd++
-		}
+// MakeDaemon backgrounds the current process using the platform's
+// Daemonizer. See DaemonAttr for the available hooks.
+func MakeDaemon(attrs *DaemonAttr) (io.Reader, io.Reader, error) {
+	if defaultDaemonizer == nil {
+		return nil, nil, fmt.Errorf("daemonizing is not supported on this platform")
 	}
+	return defaultDaemonizer.Daemonize(attrs)
+}
 
-	if stage < 2 {
-		procName, err := os.Executable()
-		if err != nil {
-			return fatal(fmt.Errorf("can't determine full path to executable: %s", err))
-		}
-
-		if len(procName) == 0 {
-			return fatal(fmt.Errorf("can't determine full path to executable"))
-		}
-
-		if stage == 1 && attrs.CaptureOutput {
-			files = files[:fileCount+2]
-
-			// stdout: write at fd:1, read at fd:fileCount
-			if files[fileCount], files[1], err = os.Pipe(); err != nil {
-				return fatal(err)
-			}
-			// stderr: write at fd:2, read at fd:fileCount+1
-			if files[fileCount+1], files[2], err = os.Pipe(); err != nil {
-				return fatal(err)
-			}
-		}
-
-		if err := advanceStage(); err != nil {
-			return fatal(err)
-		}
-		dir, _ := os.Getwd()
-		osAttrs := os.ProcAttr{Dir: dir, Env: os.Environ(), Files: files}
-
-		if stage == 0 {
-			sysattrs := syscall.SysProcAttr{Setsid: true}
-			osAttrs.Sys = &sysattrs
-		}
-
-		progName := attrs.ProgramName
-		if len(progName) == 0 {
-			progName = os.Args[0]
-		}
-		args := append([]string{progName}, os.Args[1:]...)
-		proc, err := os.StartProcess(procName, args, &osAttrs)
+// runShutdownHook races attrs.OnShutdown against attrs.ShutdownTimeout
+// instead of just blocking on it, so every Daemonizer enforces the same
+// bound: a hook that ignores ctx.Done() (a wedged network call while
+// flushing the writeback cache, say) must not be able to swallow the
+// SIGTERM/SIGINT (or SCM stop, on Windows) that triggered it, or the daemon
+// becomes killable only by SIGKILL again - the exact problem this hook
+// exists to avoid. It returns the process exit code the caller should use.
+func runShutdownHook(attrs *DaemonAttr) int {
+	timeout := attrs.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- attrs.OnShutdown(ctx) }()
+
+	select {
+	case err := <-done:
 		if err != nil {
-			return fatal(fmt.Errorf("can't create process %s: %s", procName, err))
+			fmt.Fprintf(os.Stderr, "shutdown hook failed: %s\n", err)
+			return 1
 		}
-		err = proc.Release()
-		if err != nil {
-			return nil, nil, err
-		}
-		if attrs.OnExit != nil {
-			err := attrs.OnExit(stage)
-			if err != nil {
-				return nil, nil, err
-			}
-		}
-		os.Exit(0)
-	}
-
-	//os.Chdir("/")
-	syscall.Umask(0)
-	err := resetEnv()
-	if err != nil {
-		return nil, nil, err
-	}
-
-	for fd := 3; fd < fileCount; fd++ {
-		resetFileName(fd)
-	}
-	currStage = DaemonStage(stage)
-
-	var stdout, stderr *os.File
-	if attrs.CaptureOutput {
-		stdout = os.NewFile(uintptr(fileCount), "stdout")
-		stderr = os.NewFile(uintptr(fileCount+1), "stderr")
+		return 0
+	case <-ctx.Done():
+		fmt.Fprintf(os.Stderr, "shutdown hook did not return within %s, exiting anyway\n", timeout)
+		return 1
 	}
-	return stdout, stderr, nil
 }
 
 func saveFileName(fd int, name string) {
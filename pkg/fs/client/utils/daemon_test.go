@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunShutdownHookEnforcesTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	attrs := &DaemonAttr{
+		ShutdownTimeout: 50 * time.Millisecond,
+		OnShutdown: func(ctx context.Context) error {
+			// A misbehaving hook that ignores ctx.Done() and just blocks,
+			// e.g. a wedged network call while flushing the writeback
+			// cache - exactly what ShutdownTimeout exists to bound.
+			<-block
+			return nil
+		},
+	}
+
+	done := make(chan int, 1)
+	go func() { done <- runShutdownHook(attrs) }()
+
+	select {
+	case code := <-done:
+		if code == 0 {
+			t.Fatal("expected a non-zero exit code when the hook never returns")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("runShutdownHook did not return within its ShutdownTimeout")
+	}
+}
+
+func TestRunShutdownHookPropagatesSuccess(t *testing.T) {
+	attrs := &DaemonAttr{
+		ShutdownTimeout: time.Second,
+		OnShutdown: func(ctx context.Context) error {
+			return nil
+		},
+	}
+	if code := runShutdownHook(attrs); code != 0 {
+		t.Fatalf("expected exit code 0 for a well-behaved hook, got %d", code)
+	}
+}
@@ -0,0 +1,294 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+func init() {
+	defaultDaemonizer = unixDaemonizer{}
+}
+
+// unixDaemonizer is the classic double-fork/Setsid/Umask implementation used
+// on every platform with POSIX process semantics.
+type unixDaemonizer struct{}
+
+// pidFile is the lock held by the current process on attrs.PidFile, if any.
+var pidFile *os.File
+
+// lockPidFile opens (creating if needed) and flock()s path, failing fast if
+// another live daemon already holds it. Daemonize calls it twice per run: as
+// a probe from stage 0, before the first fork, and again from the final
+// daemon, which keeps the returned file open.
+func lockPidFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("can't open pid file %s: %s", path, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		pid, readErr := ReadPidFile(path)
+		f.Close()
+		if readErr != nil {
+			return nil, fmt.Errorf("already running, but pid file %s is unreadable: %s", path, readErr)
+		}
+		return nil, fmt.Errorf("already running (pid=%d)", pid)
+	}
+	return f, nil
+}
+
+// writePid writes the current pid into the held lock file; f is kept open
+// for the lifetime of the process so the flock stays in effect.
+func writePid(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (unixDaemonizer) Daemonize(attrs *DaemonAttr) (io.Reader, io.Reader, error) {
+	stage, advanceStage, resetEnv := getStage()
+
+	fatal := func(err error) (io.Reader, io.Reader, error) {
+		if stage > 0 {
+			// By this point the original CLI invocation has already exited
+			// 0 believing the daemon started; this is the only place a
+			// failure like losing the PidFile lock race can still surface.
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "daemon failed to start: %s\n", err)
+			}
+			os.Exit(1)
+		}
+		resetErr := resetEnv()
+		if resetErr != nil {
+			return nil, nil, resetErr
+		}
+		return nil, nil, err
+	}
+
+	if stage == 0 && attrs.PidFile != "" {
+		// Fail fast here so a second invocation against the same mountpoint
+		// never even forks; the final daemon re-acquires the lock for real
+		// below since this fd does not survive stage 0 exiting.
+		probe, err := lockPidFile(attrs.PidFile)
+		if err != nil {
+			return fatal(err)
+		}
+		probe.Close()
+	}
+
+	// NOTIFY_SOCKET rides along unchanged in os.Environ() across both forks,
+	// so no special handling is needed in getStage/advanceStage to preserve
+	// it; only the connected socket fd itself needs to be threaded through.
+	notifySocketPath := os.Getenv("NOTIFY_SOCKET")
+	hasNotify := notifySocketPath != ""
+
+	fileCount := 3 + len(attrs.Files)
+	if hasNotify {
+		fileCount++
+	}
+	files := make([]*os.File, fileCount, fileCount+2)
+
+	if stage == 0 {
+		nullDev, err := os.OpenFile("/dev/null", 0, 0)
+		if err != nil {
+			return fatal(err)
+		}
+		files[0] = nullDev
+		if attrs.Stdout != nil {
+			files[1], files[2] = attrs.Stdout, attrs.Stdout
+		} else {
+			files[1], files[2] = nullDev, nullDev
+		}
+
+		fd := 3
+		for _, fPtr := range attrs.Files {
+			files[fd] = *fPtr
+			saveFileName(fd, (*fPtr).Name())
+			fd++
+		}
+		if hasNotify {
+			if nf, err := dialNotifySocket(notifySocketPath); err == nil {
+				files[fd] = nf
+				saveFileName(fd, notifySocketPath)
+				fd++
+			} else {
+				hasNotify = false
+				files = files[:fileCount-1]
+				fileCount--
+				// Stage 1/2 re-derive hasNotify from NOTIFY_SOCKET in the
+				// inherited env, with no fd threaded through for it; clear
+				// it here so they don't try to getFileName a slot that was
+				// never saved and fail the whole daemon over a stale or
+				// unreachable notify socket.
+				os.Unsetenv("NOTIFY_SOCKET")
+			}
+		}
+	} else {
+		files[0], files[1], files[2] = os.Stdin, os.Stdout, os.Stderr
+
+		fd := 3
+		for _, fPtr := range attrs.Files {
+			*fPtr = os.NewFile(uintptr(fd), getFileName(fd))
+			syscall.CloseOnExec(fd)
+			files[fd] = *fPtr
+			fd++
+		}
+		if hasNotify {
+			notifyFile = os.NewFile(uintptr(fd), getFileName(fd))
+			syscall.CloseOnExec(fd)
+			files[fd] = notifyFile
+			fd++
+		}
+	}
+
+	if stage < 2 {
+		procName, err := os.Executable()
+		if err != nil {
+			return fatal(fmt.Errorf("can't determine full path to executable: %s", err))
+		}
+
+		if len(procName) == 0 {
+			return fatal(fmt.Errorf("can't determine full path to executable"))
+		}
+
+		if stage == 1 && attrs.CaptureOutput {
+			files = files[:fileCount+2]
+
+			// stdout: write at fd:1, read at fd:fileCount
+			if files[fileCount], files[1], err = os.Pipe(); err != nil {
+				return fatal(err)
+			}
+			// stderr: write at fd:2, read at fd:fileCount+1
+			if files[fileCount+1], files[2], err = os.Pipe(); err != nil {
+				return fatal(err)
+			}
+		}
+
+		if err := advanceStage(); err != nil {
+			return fatal(err)
+		}
+		dir, _ := os.Getwd()
+		osAttrs := os.ProcAttr{Dir: dir, Env: os.Environ(), Files: files}
+
+		if stage == 0 {
+			sysattrs := syscall.SysProcAttr{Setsid: true}
+			osAttrs.Sys = &sysattrs
+		}
+
+		progName := attrs.ProgramName
+		if len(progName) == 0 {
+			progName = os.Args[0]
+		}
+		args := append([]string{progName}, os.Args[1:]...)
+		proc, err := os.StartProcess(procName, args, &osAttrs)
+		if err != nil {
+			return fatal(fmt.Errorf("can't create process %s: %s", procName, err))
+		}
+		err = proc.Release()
+		if err != nil {
+			return nil, nil, err
+		}
+		if attrs.OnExit != nil {
+			err := attrs.OnExit(stage)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		os.Exit(0)
+	}
+
+	//os.Chdir("/")
+	syscall.Umask(0)
+	err := resetEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for fd := 3; fd < fileCount; fd++ {
+		resetFileName(fd)
+	}
+	currStage = DaemonStage(stage)
+
+	if attrs.PidFile != "" {
+		f, err := lockPidFile(attrs.PidFile)
+		if err != nil {
+			// Lost the race to another daemon that forked concurrently.
+			return fatal(err)
+		}
+		if err := writePid(f); err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("can't write pid file %s: %s", attrs.PidFile, err)
+		}
+		pidFile = f
+	}
+
+	installSignalHandlers(attrs)
+	startWatchdog()
+
+	var stdout, stderr *os.File
+	if attrs.CaptureOutput {
+		stdout = os.NewFile(uintptr(fileCount), "stdout")
+		stderr = os.NewFile(uintptr(fileCount+1), "stderr")
+	}
+	return stdout, stderr, nil
+}
+
+// installSignalHandlers fans SIGHUP/SIGUSR1/SIGUSR2/SIGTERM/SIGINT out to the
+// OnReload/OnUsr1/OnUsr2/OnShutdown hooks in attrs, so the daemon can rotate
+// credentials and re-read its mount config without a hard kill -9.
+func installSignalHandlers(attrs *DaemonAttr) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		for sig := range sigc {
+			switch sig {
+			case syscall.SIGHUP:
+				if attrs.OnReload == nil {
+					continue
+				}
+				SdNotify("RELOADING=1")
+				if err := attrs.OnReload(); err != nil {
+					fmt.Fprintf(os.Stderr, "reload failed: %s\n", err)
+				}
+				SdNotifyReady()
+			case syscall.SIGUSR1:
+				if attrs.OnUsr1 != nil {
+					attrs.OnUsr1()
+				}
+			case syscall.SIGUSR2:
+				if attrs.OnUsr2 != nil {
+					attrs.OnUsr2()
+				}
+			case syscall.SIGTERM, syscall.SIGINT:
+				shutdown(attrs)
+				return
+			}
+		}
+	}()
+}
+
+// shutdown runs attrs.OnShutdown (which is expected to stop taking new FUSE
+// requests, flush the writeback cache and call fuse.Unmount) bounded by
+// ShutdownTimeout, then removes the pid file and exits, with status 1 if the
+// hook failed or did not return in time.
+func shutdown(attrs *DaemonAttr) {
+	SdNotify("STOPPING=1")
+	exitCode := 0
+	if attrs.OnShutdown != nil {
+		exitCode = runShutdownHook(attrs)
+	}
+	if pidFile != nil {
+		os.Remove(pidFile.Name())
+	}
+	os.Exit(exitCode)
+}
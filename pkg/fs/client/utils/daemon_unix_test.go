@@ -0,0 +1,99 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLockPidFileExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pfs-fuse.pid")
+
+	f, err := lockPidFile(path)
+	if err != nil {
+		t.Fatalf("lockPidFile: %s", err)
+	}
+	defer f.Close()
+
+	if err := writePid(f); err != nil {
+		t.Fatalf("writePid: %s", err)
+	}
+
+	pid, err := ReadPidFile(path)
+	if err != nil {
+		t.Fatalf("ReadPidFile: %s", err)
+	}
+	if pid != os.Getpid() {
+		t.Fatalf("got pid %d, want %d", pid, os.Getpid())
+	}
+
+	if _, err := lockPidFile(path); err == nil {
+		t.Fatal("lockPidFile on an already-held lock should fail, as when a " +
+			"second mount races the first for the same mountpoint")
+	}
+}
+
+func TestLockPidFileReleasedOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pfs-fuse.pid")
+
+	f, err := lockPidFile(path)
+	if err != nil {
+		t.Fatalf("lockPidFile: %s", err)
+	}
+	f.Close()
+
+	f2, err := lockPidFile(path)
+	if err != nil {
+		t.Fatalf("lockPidFile after the first holder released it: %s", err)
+	}
+	f2.Close()
+}
+
+// TestInstallSignalHandlersReloadAndUsrHooks sends real SIGHUP/SIGUSR1/
+// SIGUSR2 to the test process and checks installSignalHandlers dispatches
+// them to OnReload/OnUsr1/OnUsr2. It deliberately never sends SIGTERM/SIGINT,
+// since that path calls shutdown(), which calls os.Exit and would kill the
+// test binary; the shutdown-timeout enforcement itself is covered directly
+// by TestRunShutdownHookEnforcesTimeout in daemon_test.go.
+func TestInstallSignalHandlersReloadAndUsrHooks(t *testing.T) {
+	var reloaded, usr1, usr2 int32
+	attrs := &DaemonAttr{
+		OnReload: func() error { atomic.AddInt32(&reloaded, 1); return nil },
+		OnUsr1:   func() { atomic.AddInt32(&usr1, 1) },
+		OnUsr2:   func() { atomic.AddInt32(&usr2, 1) },
+	}
+	installSignalHandlers(attrs)
+
+	waitFor := func(name string, count *int32) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if atomic.LoadInt32(count) > 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s hook was not invoked within the deadline", name)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("kill SIGHUP: %s", err)
+	}
+	waitFor("OnReload", &reloaded)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("kill SIGUSR1: %s", err)
+	}
+	waitFor("OnUsr1", &usr1)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatalf("kill SIGUSR2: %s", err)
+	}
+	waitFor("OnUsr2", &usr2)
+}
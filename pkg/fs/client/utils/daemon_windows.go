@@ -0,0 +1,273 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func init() {
+	defaultDaemonizer = windowsDaemonizer{}
+}
+
+// windowsDaemonizer backgrounds pfs-fuse with a single CreateProcess call
+// instead of the double-fork dance in daemon_unix.go: Windows has no
+// fork(), and a detached, new-process-group child is already fully
+// disconnected from the launching console. For real service lifecycle
+// (start/stop/reload from the SCM against WinFsp) pfs-fuse should instead be
+// registered with RegisterWindowsService and run under the SCM, in which
+// case Daemonize detects that and starts the svc.Run dispatch loop itself.
+//
+// The stage-tracking env var and hex-encoded fd scheme from daemon_unix.go
+// don't apply here: Windows handle numbers aren't stable across
+// CreateProcess, so inherited files ride along via
+// syscall.SysProcAttr.AdditionalInheritedHandles instead.
+type windowsDaemonizer struct{}
+
+const relaunchedVar = "__DAEMON_RELAUNCHED"
+
+var pidFile *os.File
+
+// lockPidFile opens path sharing read access only, so unlike a plain
+// os.OpenFile (which Go always opens with FILE_SHARE_READ|FILE_SHARE_WRITE)
+// a second process trying to open the same path for writing is refused by
+// the OS, giving us the same exclusivity that syscall.Flock gives the unix
+// build, while ReadPidFile can still read the pid of the holder.
+func lockPidFile(path string) (*os.File, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(pathPtr,
+		windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ, // readers only: no second writer
+		nil,
+		windows.OPEN_ALWAYS,
+		windows.FILE_ATTRIBUTE_NORMAL,
+		0)
+	if err != nil {
+		pid, readErr := ReadPidFile(path)
+		if readErr != nil {
+			return nil, fmt.Errorf("already running, but pid file %s is unreadable: %s", path, readErr)
+		}
+		return nil, fmt.Errorf("already running (pid=%d)", pid)
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+func writePid(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (windowsDaemonizer) Daemonize(attrs *DaemonAttr) (io.Reader, io.Reader, error) {
+	if running, err := svc.IsWindowsService(); err == nil && running {
+		return runAsService(attrs)
+	}
+	if os.Getenv(relaunchedVar) != "" {
+		return runDetached(attrs)
+	}
+
+	if attrs.PidFile != "" {
+		probe, err := lockPidFile(attrs.PidFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		probe.Close()
+	}
+
+	procName, err := os.Executable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't determine full path to executable: %s", err)
+	}
+
+	progName := attrs.ProgramName
+	if len(progName) == 0 {
+		progName = os.Args[0]
+	}
+	cmd := exec.Command(procName, os.Args[1:]...)
+	cmd.Args[0] = progName
+	cmd.Env = append(os.Environ(), relaunchedVar+"=1")
+	sysAttr := &syscall.SysProcAttr{
+		CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP,
+	}
+	for _, fPtr := range attrs.Files {
+		if *fPtr == nil {
+			continue
+		}
+		h := windows.Handle((*fPtr).Fd())
+		// Handles aren't inheritable by default; os.OpenFile leaves them
+		// marked non-inheritable, so CreateProcess would otherwise hand the
+		// child a garbage handle value.
+		if err := windows.SetHandleInformation(h, windows.HANDLE_FLAG_INHERIT, windows.HANDLE_FLAG_INHERIT); err != nil {
+			return nil, nil, fmt.Errorf("can't mark handle inheritable: %s", err)
+		}
+		sysAttr.AdditionalInheritedHandles = append(sysAttr.AdditionalInheritedHandles, syscall.Handle(h))
+	}
+	cmd.SysProcAttr = sysAttr
+	if attrs.Stdout != nil {
+		cmd.Stdout, cmd.Stderr = attrs.Stdout, attrs.Stdout
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("can't create process %s: %s", procName, err)
+	}
+	if attrs.OnExit != nil {
+		if err := attrs.OnExit(0); err != nil {
+			return nil, nil, err
+		}
+	}
+	os.Exit(0)
+	return nil, nil, nil
+}
+
+// runDetached is the body of the relaunched background process started by
+// the interactive Daemonize call above.
+func runDetached(attrs *DaemonAttr) (io.Reader, io.Reader, error) {
+	currStage = StageDaemon
+
+	if attrs.PidFile != "" {
+		f, err := lockPidFile(attrs.PidFile)
+		if err != nil {
+			// The original CLI invocation has already exited 0 believing
+			// the daemon started; this is the only place left to surface
+			// losing the PidFile lock race against a concurrent mount.
+			fmt.Fprintf(os.Stderr, "daemon failed to start: %s\n", err)
+			os.Exit(1)
+		}
+		if err := writePid(f); err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("can't write pid file %s: %s", attrs.PidFile, err)
+		}
+		pidFile = f
+	}
+
+	// There is no SIGHUP/SIGUSR1/SIGUSR2 equivalent for a plain background
+	// process on Windows; OnReload/OnUsr1 are only reachable when pfs-fuse
+	// is registered and run as a real Windows service, below. OnUsr2 has no
+	// trigger on Windows.
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt)
+	go func() {
+		<-sigc
+		runShutdown(attrs)
+	}()
+
+	return nil, nil, nil
+}
+
+func runAsService(attrs *DaemonAttr) (io.Reader, io.Reader, error) {
+	currStage = StageDaemon
+
+	if attrs.PidFile != "" {
+		f, err := lockPidFile(attrs.PidFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := writePid(f); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		pidFile = f
+	}
+
+	name := attrs.ProgramName
+	if name == "" {
+		name = "pfs-fuse"
+	}
+	go svc.Run(name, &fuseService{attrs: attrs})
+	return nil, nil, nil
+}
+
+func runShutdown(attrs *DaemonAttr) {
+	exitCode := 0
+	if attrs.OnShutdown != nil {
+		exitCode = runShutdownHook(attrs)
+	}
+	if pidFile != nil {
+		os.Remove(pidFile.Name())
+	}
+	os.Exit(exitCode)
+}
+
+// Custom service control codes, triggered e.g. via `sc control <name> 130`,
+// standing in for the SIGHUP/SIGUSR1 hooks available on Unix.
+const (
+	svcControlReload = svc.Cmd(130)
+	svcControlUsr1   = svc.Cmd(131)
+)
+
+// fuseService adapts DaemonAttr's hooks to the Windows Service Control
+// Manager dispatch loop.
+type fuseService struct {
+	attrs *DaemonAttr
+}
+
+func (s *fuseService) Execute(args []string, r <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for req := range r {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			runShutdown(s.attrs)
+			status <- svc.Status{State: svc.Stopped}
+			return false, 0
+		case svcControlReload:
+			if s.attrs.OnReload != nil {
+				s.attrs.OnReload()
+			}
+		case svcControlUsr1:
+			if s.attrs.OnUsr1 != nil {
+				s.attrs.OnUsr1()
+			}
+		}
+	}
+	return false, 0
+}
+
+// RegisterWindowsService installs pfs-fuse as a Windows service named name,
+// running exePath with args, so `pfs-fuse mount` can be managed through the
+// SCM instead of the detached-process fallback.
+func RegisterWindowsService(name, exePath string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("can't connect to service manager: %s", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+
+	s, err = m.CreateService(name, exePath, mgr.Config{
+		DisplayName: name,
+		Description: "PaddleFlow pfs-fuse mount service",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("can't create service %s: %s", name, err)
+	}
+	defer s.Close()
+	return nil
+}
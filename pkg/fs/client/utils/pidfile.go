@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReadPidFile reads and parses the pid written by MakeDaemon to path, so that
+// commands like umount/stats can locate a running mount without walking /proc.
+func ReadPidFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("can't read pid file %s: %s", path, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("bad pid file %s: %s", path, err)
+	}
+	return pid, nil
+}
+
+// SendSignal reads the pid from pidFile and delivers sig to that process.
+func SendSignal(pidFile string, sig os.Signal) error {
+	pid, err := ReadPidFile(pidFile)
+	if err != nil {
+		return err
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("can't find process %d: %s", pid, err)
+	}
+	if err := proc.Signal(sig); err != nil {
+		return fmt.Errorf("can't signal process %d: %s", pid, err)
+	}
+	return nil
+}
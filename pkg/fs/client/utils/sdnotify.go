@@ -0,0 +1,68 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// notifyFile is the long-lived handle to NOTIFY_SOCKET, set up once in
+// MakeDaemon and reused for every SdNotify call made by the final daemon.
+var notifyFile *os.File
+
+// dialNotifySocket opens the systemd notification socket at path (which may
+// be an "@abstract" name) as a connected SOCK_DGRAM unix socket.
+func dialNotifySocket(path string) (*os.File, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	f, err := conn.File()
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SdNotify sends a single systemd notification state line (e.g. "READY=1")
+// over NOTIFY_SOCKET. It is a no-op, returning nil, when the daemon wasn't
+// started under a Type=notify unit.
+func SdNotify(state string) error {
+	if notifyFile == nil {
+		return nil
+	}
+	if _, err := notifyFile.Write([]byte(state + "\n")); err != nil {
+		return fmt.Errorf("sd_notify %q failed: %s", state, err)
+	}
+	return nil
+}
+
+// SdNotifyReady tells systemd the daemon has finished starting up.
+func SdNotifyReady() error {
+	return SdNotify("READY=1")
+}
+
+// startWatchdog pings WATCHDOG=1 at half of WATCHDOG_USEC, as systemd
+// requires, for as long as the process runs. It is a no-op if WATCHDOG_USEC
+// isn't set or NOTIFY_SOCKET isn't wired up.
+func startWatchdog() {
+	if notifyFile == nil {
+		return
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	go func() {
+		for range time.Tick(interval) {
+			SdNotify("WATCHDOG=1")
+		}
+	}()
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package utils
+
+// SdNotify is a no-op on Windows: there is no systemd NOTIFY_SOCKET to talk
+// to. It exists so callers in the mount/warmup/bench commands can call it
+// unconditionally without their own per-platform build tags.
+func SdNotify(state string) error {
+	return nil
+}
+
+// SdNotifyReady is a no-op on Windows; see SdNotify.
+func SdNotifyReady() error {
+	return nil
+}
@@ -0,0 +1,126 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileWatcherFiresOnAtomicRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("a: 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldDebounce := watchDebounce
+	watchDebounce = 20 * time.Millisecond
+	defer func() { watchDebounce = oldDebounce }()
+
+	changed := make(chan struct{}, 1)
+	w := NewFileWatcher(func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer w.Close()
+
+	if err := w.Watch(path); err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+
+	// Editor-style atomic rewrite: write a sibling file, then rename it over
+	// the watched path.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte("a: 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange was not called after atomic rewrite")
+	}
+}
+
+func TestFileWatcherDebouncesBurst(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("a: 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldDebounce := watchDebounce
+	watchDebounce = 100 * time.Millisecond
+	defer func() { watchDebounce = oldDebounce }()
+
+	var calls int32
+	w := NewFileWatcher(func() { atomic.AddInt32(&calls, 1) })
+	defer w.Close()
+
+	if err := w.Watch(path); err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(path, []byte{byte('0' + i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected exactly one debounced onChange call for a write burst, got %d", got)
+	}
+}
+
+func TestFileWatcherPollFallback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("a: 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldPoll := watchPollEvery
+	watchPollEvery = 50 * time.Millisecond
+	defer func() { watchPollEvery = oldPoll }()
+
+	changed := make(chan struct{}, 1)
+	w := NewFileWatcher(func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer w.Close()
+	// Simulate inotify being unavailable, e.g. the overlayfs/NFS CI mock
+	// case, so Watch falls back to mtime polling.
+	w.Close()
+	w.fd = -1
+
+	if err := w.Watch(path); err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("a: 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("onChange was not called by the poll fallback")
+	}
+}
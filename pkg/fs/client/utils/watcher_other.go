@@ -0,0 +1,111 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const watchPollEvery = 30 * time.Second
+
+// FileWatcher watches a set of files for content changes by polling mtimes.
+// golang.org/x/sys/unix's inotify syscalls are Linux-only (see
+// watcher_linux.go for that fast path); darwin/freebsd get this poll-only
+// implementation until a kqueue-based backend replaces it.
+type FileWatcher struct {
+	onChange func()
+
+	mu      sync.Mutex
+	mtimes  map[string]time.Time // absolute path -> last known mtime
+	started bool
+}
+
+// NewFileWatcher creates a watcher that calls onChange whenever a watched
+// file changes. Call Watch to add paths.
+func NewFileWatcher(onChange func()) *FileWatcher {
+	return &FileWatcher{
+		onChange: onChange,
+		mtimes:   make(map[string]time.Time),
+	}
+}
+
+// WatchConfig starts watching paths (typically the mount config file and the
+// AK/SK credential file) and fires attrs.OnReload, wrapped in the same
+// sd_notify RELOADING=1/READY=1 pair used for SIGHUP, whenever any of them
+// change.
+func WatchConfig(attrs *DaemonAttr, paths ...string) (*FileWatcher, error) {
+	w := NewFileWatcher(func() {
+		if attrs.OnReload == nil {
+			return
+		}
+		SdNotify("RELOADING=1")
+		if err := attrs.OnReload(); err != nil {
+			fmt.Fprintf(os.Stderr, "config reload failed: %s\n", err)
+		}
+		SdNotifyReady()
+	})
+	for _, p := range paths {
+		if err := w.Watch(p); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Watch adds path to the watch set, starting the poll loop the first time
+// it's called.
+func (w *FileWatcher) Watch(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	first := !w.started
+	w.started = true
+	if st, statErr := os.Stat(abs); statErr == nil {
+		w.mtimes[abs] = st.ModTime()
+	} else {
+		w.mtimes[abs] = time.Time{}
+	}
+	w.mu.Unlock()
+
+	if first {
+		go w.runPoll()
+	}
+	return nil
+}
+
+// Close does nothing: the poll loop has no fd to release and is not stopped.
+// It is kept only to match watcher_linux.go's API.
+func (w *FileWatcher) Close() error {
+	return nil
+}
+
+func (w *FileWatcher) runPoll() {
+	ticker := time.NewTicker(watchPollEvery)
+	defer ticker.Stop()
+	for range ticker.C {
+		changed := false
+		w.mu.Lock()
+		for path, last := range w.mtimes {
+			st, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if st.ModTime().After(last) {
+				w.mtimes[path] = st.ModTime()
+				changed = true
+			}
+		}
+		w.mu.Unlock()
+		if changed {
+			w.onChange()
+		}
+	}
+}
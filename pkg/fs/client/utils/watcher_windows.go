@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+package utils
+
+// FileWatcher is a no-op stand-in on Windows: config/credential hot-reload
+// via a file watcher isn't wired up for this platform yet. It exists so
+// callers in the mount/warmup/bench commands can use NewFileWatcher/
+// WatchConfig unconditionally without their own per-platform build tags.
+type FileWatcher struct{}
+
+// NewFileWatcher returns a FileWatcher whose Watch is a no-op on Windows.
+func NewFileWatcher(onChange func()) *FileWatcher {
+	return &FileWatcher{}
+}
+
+// WatchConfig is a no-op on Windows; it neither watches paths nor errors, so
+// callers don't need to special-case this platform.
+func WatchConfig(attrs *DaemonAttr, paths ...string) (*FileWatcher, error) {
+	return &FileWatcher{}, nil
+}
+
+// Watch is a no-op on Windows.
+func (w *FileWatcher) Watch(path string) error {
+	return nil
+}
+
+// Close is a no-op on Windows.
+func (w *FileWatcher) Close() error {
+	return nil
+}